@@ -0,0 +1,128 @@
+// Copyright 2024 Dave van Soest. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package johansongen provides generic, type-safe convenience helpers on
+// top of the johanson streaming JSON API, for callers on Go 1.18+.
+// It only writes through the exported V/K methods of the johanson package,
+// so all of the pause/resume invariants of that API still apply.
+package johansongen
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/tbknl/go-johanson"
+)
+
+// Writable can be implemented by a type to stream itself as JSON using the
+// johanson V context directly, instead of going through Value's type
+// switch or being handed to V.Marshal.
+type Writable interface {
+	WriteJSON(johanson.V)
+}
+
+// Value writes x to v, picking the JSON representation based on the
+// dynamic type of x: the johanson primitive writers for the numeric
+// kinds, string, bool and []byte (base64-encoded), time.RFC3339 for
+// time.Time, WriteJSON for a Writable, and v.Marshal as a fallback for
+// anything else.
+func Value[T any](v johanson.V, x T) {
+	if w, ok := any(x).(Writable); ok {
+		w.WriteJSON(v)
+		return
+	}
+
+	switch val := any(x).(type) {
+	case bool:
+		v.Bool(val)
+	case string:
+		v.String(val)
+	case int:
+		v.Int(int64(val))
+	case int8:
+		v.Int(int64(val))
+	case int16:
+		v.Int(int64(val))
+	case int32:
+		v.Int(int64(val))
+	case int64:
+		v.Int(val)
+	case uint:
+		v.Uint(uint64(val))
+	case uint8:
+		v.Uint(uint64(val))
+	case uint16:
+		v.Uint(uint64(val))
+	case uint32:
+		v.Uint(uint64(val))
+	case uint64:
+		v.Uint(val)
+	case float32:
+		v.Float(float64(val))
+	case float64:
+		v.Float(val)
+	case []byte:
+		v.String(base64.StdEncoding.EncodeToString(val))
+	case time.Time:
+		v.String(val.Format(time.RFC3339))
+	default:
+		v.Marshal(x)
+	}
+}
+
+// Field is shorthand for Value(o.Item(key), x).
+func Field[T any](o johanson.K, key string, x T) {
+	Value(o.Item(key), x)
+}
+
+// FieldOmitEmpty is like Field, but skips writing the item entirely when x
+// is the zero value for its type.
+func FieldOmitEmpty[T any](o johanson.K, key string, x T) {
+	rv := reflect.ValueOf(x)
+	if !rv.IsValid() || rv.IsZero() {
+		return
+	}
+	Field(o, key, x)
+}
+
+// WriteSlice opens an array context on v and calls each once per element
+// of xs, with a value context to write that element to.
+func WriteSlice[T any](v johanson.V, xs []T, each func(johanson.V, T)) {
+	v.Array(func(a johanson.V) {
+		for _, x := range xs {
+			each(a, x)
+		}
+	})
+}
+
+// WriteMap opens an object context on v and calls each once per entry of
+// m, with the object context to add the entry's item(s) to. Entries are
+// visited in ascending key order (using MK's natural string form, via a
+// direct comparison when MK is string itself), matching the deterministic
+// key ordering obj.Marshal already uses for map[string]interface{} -
+// iterating m directly would make output order vary from run to run.
+func WriteMap[MK comparable, MV any](v johanson.V, m map[MK]MV, each func(johanson.K, MK, MV)) {
+	keys := make([]MK, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	if _, ok := any(keys).([]string); ok {
+		sort.Slice(keys, func(i, j int) bool {
+			return any(keys[i]).(string) < any(keys[j]).(string)
+		})
+	} else {
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+	}
+
+	v.Object(func(o johanson.K) {
+		for _, key := range keys {
+			each(o, key, m[key])
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package johansongen_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tbknl/go-johanson"
+	"github.com/tbknl/go-johanson/johansongen"
+)
+
+type point struct {
+	X, Y int
+}
+
+func (p point) WriteJSON(v johanson.V) {
+	v.Object(func(o johanson.K) {
+		o.Item("x").Int(int64(p.X))
+		o.Item("y").Int(int64(p.Y))
+	})
+}
+
+func Test_Value(t *testing.T) {
+	testCases := []struct {
+		expected string
+		fn       func(johanson.V)
+	}{
+		{expected: `true`, fn: func(v johanson.V) { johansongen.Value(v, true) }},
+		{expected: `"abc"`, fn: func(v johanson.V) { johansongen.Value(v, "abc") }},
+		{expected: `-123`, fn: func(v johanson.V) { johansongen.Value(v, -123) }},
+		{expected: `456`, fn: func(v johanson.V) { johansongen.Value(v, uint(456)) }},
+		{expected: `987.5`, fn: func(v johanson.V) { johansongen.Value(v, 987.5) }},
+		{expected: `"YWJj"`, fn: func(v johanson.V) { johansongen.Value(v, []byte("abc")) }},
+		{
+			expected: `"2024-01-02T03:04:05Z"`,
+			fn: func(v johanson.V) {
+				johansongen.Value(v, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+			},
+		},
+		{expected: `{"x":1,"y":2}`, fn: func(v johanson.V) { johansongen.Value(v, point{X: 1, Y: 2}) }},
+		{expected: `{"a":1}`, fn: func(v johanson.V) { johansongen.Value(v, map[string]int{"a": 1}) }},
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w)
+		tc.fn(v)
+		if want, got := tc.expected, w.String(); want != got {
+			t.Errorf("Test case %d: got %s instead of %s", i, got, want)
+		}
+	}
+}
+
+func Test_Field(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w)
+	v.Object(func(o johanson.K) {
+		johansongen.Field(o, "x", 123)
+		johansongen.Field(o, "y", "abc")
+		johansongen.Field(o, "p", point{X: 1, Y: 2})
+	})
+
+	if want, got := `{"x":123,"y":"abc","p":{"x":1,"y":2}}`, w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_FieldOmitEmpty(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w)
+	v.Object(func(o johanson.K) {
+		johansongen.FieldOmitEmpty(o, "a", 0)
+		johansongen.FieldOmitEmpty(o, "b", "")
+		johansongen.FieldOmitEmpty(o, "c", "non-empty")
+		johansongen.FieldOmitEmpty(o, "d", 42)
+	})
+
+	if want, got := `{"c":"non-empty","d":42}`, w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_WriteSlice(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w)
+	johansongen.WriteSlice(v, []int{1, 2, 3}, func(a johanson.V, x int) {
+		a.Int(int64(x * 10))
+	})
+
+	if want, got := `[10,20,30]`, w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_WriteMap(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w)
+	johansongen.WriteMap(v, map[string]int{"x": 1}, func(o johanson.K, key string, val int) {
+		o.Item(key).Int(int64(val))
+	})
+
+	if want, got := `{"x":1}`, w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_WriteMapKeyOrderIsDeterministic(t *testing.T) {
+	m := map[string]int{"banana": 2, "apple": 1, "cherry": 3}
+
+	var first string
+	for i := 0; i < 10; i++ {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w)
+		johansongen.WriteMap(v, m, func(o johanson.K, key string, val int) {
+			o.Item(key).Int(int64(val))
+		})
+
+		if i == 0 {
+			first = w.String()
+		} else if got := w.String(); got != first {
+			t.Errorf("run %d: got %s instead of %s", i, got, first)
+		}
+	}
+
+	if want := `{"apple":1,"banana":2,"cherry":3}`; first != want {
+		t.Errorf("got %s instead of %s", first, want)
+	}
+}
+
+func Test_WriteMapIntKeyOrderIsDeterministic(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w)
+	johansongen.WriteMap(v, map[int]string{3: "c", 1: "a", 2: "b"}, func(o johanson.K, key int, val string) {
+		johansongen.Field(o, strconv.Itoa(key), val)
+	})
+
+	if want, got := `{"1":"a","2":"b","3":"c"}`, w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
@@ -1,8 +1,12 @@
 package johanson_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -57,6 +61,26 @@ func Test_StringEscaping(t *testing.T) {
 	}
 }
 
+func Test_StringInvalidUTF8(t *testing.T) {
+	testCases := []struct {
+		value string
+	}{
+		{"\xff\xfe"},
+		{"valid \xff invalid"},
+		{string([]byte{0xc3, 0x28})}, // Invalid 2-byte sequence.
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w)
+		v.String(tc.value)
+		want, _ := json.Marshal(tc.value)
+		if got := w.String(); string(want) != got {
+			t.Errorf("Invalid UTF-8 test case %d: got %s instead of %s", i, got, want)
+		}
+	}
+}
+
 func Test_StringObjectKeyEscaping(t *testing.T) {
 	testCases := []struct {
 		key string
@@ -442,6 +466,23 @@ func Test_MarshalError(t *testing.T) {
 	}
 }
 
+func Test_FloatSpecialValues(t *testing.T) {
+	testCases := []float64{math.NaN(), math.Inf(1), math.Inf(-1)}
+
+	for _, f := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w)
+		v.Float(f)
+
+		if v.Error() == nil {
+			t.Errorf("Float(%v): expected an error but got none", f)
+		}
+		if got := w.String(); got != "" {
+			t.Errorf("Float(%v): expected nothing written but got %q", f, got)
+		}
+	}
+}
+
 func Test_Finished(t *testing.T) {
 	w := &strings.Builder{}
 	v := johanson.NewStreamWriter(w)
@@ -478,9 +519,222 @@ func (sbw *StringBuilderWrapper) Write(p []byte) (int, error) {
 	return n, err
 }
 
+func Test_IndentedArray(t *testing.T) {
+	testCases := []struct {
+		value interface{}
+		fn    func(johanson.V)
+	}{
+		{value: []interface{}{}, fn: func(v johanson.V) { v.Array(nil) }},
+		{
+			value: []interface{}{123},
+			fn: func(v johanson.V) {
+				v.Array(func(a johanson.V) {
+					a.Int(123)
+				})
+			},
+		},
+		{
+			value: []interface{}{123, "abc", []interface{}{"nested"}, true},
+			fn: func(v johanson.V) {
+				v.Array(func(a johanson.V) {
+					a.Int(123)
+					a.String("abc")
+					a.Array(func(a2 johanson.V) {
+						a2.String("nested")
+					})
+					a.Bool(true)
+				})
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewIndentedStreamWriter(w, "", "  ")
+		tc.fn(v)
+		want, _ := json.MarshalIndent(tc.value, "", "  ")
+		if got := w.String(); string(want) != got {
+			t.Errorf("Test case %d: got %s instead of %s", i, got, want)
+		}
+	}
+}
+
+func Test_IndentedObject(t *testing.T) {
+	testCases := []struct {
+		expected string
+		fn       func(johanson.V)
+	}{
+		{expected: `{}`, fn: func(v johanson.V) { v.Object(nil) }},
+		{
+			expected: "{\n>> \t\"x\": 123\n>> }",
+			fn: func(v johanson.V) {
+				v.Object(func(obj johanson.K) {
+					obj.Item("x").Int(123)
+				})
+			},
+		},
+		{
+			expected: "{\n>> \t\"x\": 123,\n>> \t\"nested\": {\n>> \t\t\"y\": [\n>> \t\t\tfalse\n>> \t\t]\n>> \t},\n>> \t\"z\": {}\n>> }",
+			fn: func(v johanson.V) {
+				v.Object(func(obj johanson.K) {
+					obj.Item("x").Int(123)
+					obj.Item("nested").Object(func(nested johanson.K) {
+						nested.Item("y").Array(func(a johanson.V) {
+							a.Bool(false)
+						})
+					})
+					obj.Item("z").Object(nil)
+				})
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewIndentedStreamWriter(w, ">> ", "\t")
+		tc.fn(v)
+		if want, got := tc.expected, w.String(); want != got {
+			t.Errorf("Test case %d: got %s instead of %s", i, got, want)
+		}
+	}
+}
+
+func Test_IndentedMarshalInsideObject(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewIndentedStreamWriter(w, "", "  ")
+	v.Object(func(obj johanson.K) {
+		obj.Item("x").Int(123)
+		obj.Marshal(map[string]interface{}{
+			"mA": "abc",
+			"mB": "def",
+		})
+		obj.Item("y").Bool(true)
+	})
+
+	want := "{\n  \"x\": 123,\n  \"mA\": \"abc\",\n  \"mB\": \"def\",\n  \"y\": true\n}"
+	if got := w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_EscapeHTMLDisabled(t *testing.T) {
+	testCases := []struct {
+		value string
+	}{
+		{"<script>alert(1)</script>"},
+		{"Tom & Jerry"},
+		{`no special chars here`},
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w, johanson.EscapeHTML(false))
+		v.String(tc.value)
+		want, _ := json.Marshal(tc.value)
+		want = bytes.ReplaceAll(want, []byte("\\u003c"), []byte("<"))
+		want = bytes.ReplaceAll(want, []byte("\\u003e"), []byte(">"))
+		want = bytes.ReplaceAll(want, []byte("\\u0026"), []byte("&"))
+		if got := w.String(); string(want) != got {
+			t.Errorf("Test case %d: got %s instead of %s", i, got, want)
+		}
+	}
+}
+
+func Test_EscapeHTMLDisabledObjectKey(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w, johanson.EscapeHTML(false))
+	v.Object(func(obj johanson.K) {
+		obj.Item("a<b&c").Int(1)
+	})
+
+	if want, got := `{"a<b&c":1}`, w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_EscapeHTMLEnabledByDefault(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewStreamWriter(w)
+	v.String("<b>")
+
+	if want, got := "\"\\u003cb\\u003e\"", w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_MarshalEscapeHTMLDisabled(t *testing.T) {
+	testCases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"<script>alert(1)</script>", `"<script>alert(1)</script>"`},
+		{"Tom & Jerry", `"Tom & Jerry"`},
+		// A literal backslash immediately followed by the literal text
+		// "u003c" is doubled by json.Marshal to `\\u003c`, which must not
+		// be mistaken for the HTML escape sequence of `<`: there is no `<`,
+		// `>` or `&` character in this value at all, so disabling HTML
+		// escaping must not change the output.
+		{"foo " + string([]byte{'\\', 'u', '0', '0', '3', 'c'}) + " bar", `"foo \\u003c bar"`},
+		{map[string]interface{}{"a": "<b>"}, `{"a":"<b>"}`},
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w, johanson.EscapeHTML(false))
+		if err := v.Marshal(tc.value); err != nil {
+			t.Fatalf("Test case %d: unexpected Marshal error: %s", i, err)
+		}
+
+		if want, got := tc.expected, w.String(); want != got {
+			t.Errorf("Test case %d: got %s instead of %s", i, got, want)
+		}
+
+		var roundTripped interface{}
+		if err := json.Unmarshal([]byte(w.String()), &roundTripped); err != nil {
+			t.Errorf("Test case %d: output is not valid JSON: %s (%q)", i, err, w.String())
+		}
+	}
+}
+
+func Test_Raw(t *testing.T) {
+	testCases := []struct {
+		expected string
+		fn       func(johanson.V)
+	}{
+		{
+			expected: `{"x":1,"y":[2,3]}`,
+			fn: func(v johanson.V) {
+				v.Object(func(obj johanson.K) {
+					obj.Item("x").Raw([]byte("1"))
+					obj.Item("y").Raw(json.RawMessage(`[2,3]`))
+				})
+			},
+		},
+		{
+			expected: `[1,{"a":true},2]`,
+			fn: func(v johanson.V) {
+				v.Array(func(a johanson.V) {
+					a.Int(1)
+					a.Raw([]byte(`{"a":true}`))
+					a.Int(2)
+				})
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		w := &strings.Builder{}
+		v := johanson.NewStreamWriter(w)
+		tc.fn(v)
+		if want, got := tc.expected, w.String(); want != got {
+			t.Errorf("Test case %d: got %s instead of %s", i, got, want)
+		}
+	}
+}
+
 func Test_WriterErrorCheck(t *testing.T) {
 	w := &StringBuilderWrapper{Limit: 10}
-	v := johanson.NewStreamWriter(w)
+	v := johanson.NewStreamWriter(w, johanson.BufferSize(0))
 
 	if v.Error() != nil {
 		t.Fatalf("Expected new stream to not have writer error but instead it has.")
@@ -504,3 +758,152 @@ func Test_WriterErrorCheck(t *testing.T) {
 		t.Fatalf("Expected stream to have writer error but instead it has not.")
 	}
 }
+
+func Test_JSONLinesZeroRecords(t *testing.T) {
+	w := &strings.Builder{}
+	johanson.NewJSONLinesWriter(w)
+
+	if want, got := "", w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_JSONLinesMixedRecords(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewJSONLinesWriter(w, johanson.BufferSize(0))
+
+	v.Int(123)
+	v.Array(func(a johanson.V) {
+		a.String("abc")
+		a.Bool(true)
+	})
+	v.Object(func(obj johanson.K) {
+		obj.Item("x").Int(1)
+	})
+
+	want := "123\n[\"abc\",true]\n{\"x\":1}"
+	if got := w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+func Test_JSONLinesErrorPropagation(t *testing.T) {
+	w := &StringBuilderWrapper{Limit: 10}
+	v := johanson.NewJSONLinesWriter(w, johanson.BufferSize(0))
+
+	v.Int(12345)
+	if v.Error() != nil {
+		t.Fatalf("Expected stream to not have writer error but instead it has.")
+	}
+
+	v.Int(67890)
+	if v.Error() == nil {
+		t.Fatalf("Expected stream to have writer error but instead it has not.")
+	}
+}
+
+func Test_JSONLinesNestedIsolation(t *testing.T) {
+	w := &strings.Builder{}
+	v := johanson.NewJSONLinesWriter(w, johanson.BufferSize(0))
+
+	v.Array(func(a johanson.V) {
+		v.Int(123) // Ignored: root is paused while this record is being written.
+		a.Int(456)
+	})
+	v.Int(789)
+
+	if want, got := "[456]\n789", w.String(); want != got {
+		t.Errorf("got %s instead of %s", got, want)
+	}
+}
+
+type writeCountingWriter struct {
+	io.Writer
+	writes int
+}
+
+func (w *writeCountingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Writer.Write(p)
+}
+
+func Test_JSONLinesBatchesUnderDefaultBufferSize(t *testing.T) {
+	const n = 1000
+	sb := &strings.Builder{}
+	w := &writeCountingWriter{Writer: sb}
+	v := johanson.NewJSONLinesWriter(w) // Default buffer size: records should batch.
+
+	for i := 0; i < n; i++ {
+		v.Int(int64(i))
+	}
+
+	if w.writes >= n {
+		t.Errorf("expected the %d records to be batched into fewer than %d underlying writes, got %d", n, n, w.writes)
+	}
+
+	v.Flush()
+	var want strings.Builder
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			want.WriteByte('\n')
+		}
+		want.WriteString(strconv.Itoa(i))
+	}
+	if got := sb.String(); want.String() != got {
+		t.Errorf("got %s instead of %s", got, want.String())
+	}
+}
+
+func Benchmark_LargeArrayOfInts(b *testing.B) {
+	const n = 10000
+
+	b.Run("johanson", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			v := johanson.NewStreamWriter(io.Discard)
+			v.Array(func(a johanson.V) {
+				for j := 0; j < n; j++ {
+					a.Int(int64(j))
+				}
+			})
+		}
+	})
+
+	b.Run("encoding/json", func(b *testing.B) {
+		nums := make([]int, n)
+		for j := range nums {
+			nums[j] = j
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			enc := json.NewEncoder(io.Discard)
+			enc.Encode(nums)
+		}
+	})
+}
+
+func Benchmark_ObjectOfStrings(b *testing.B) {
+	const n = 1000
+
+	b.Run("johanson", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			v := johanson.NewStreamWriter(io.Discard)
+			v.Object(func(o johanson.K) {
+				for j := 0; j < n; j++ {
+					o.Item(fmt.Sprintf("key%d", j)).String("some string value")
+				}
+			})
+		}
+	})
+
+	b.Run("encoding/json", func(b *testing.B) {
+		m := make(map[string]string, n)
+		for j := 0; j < n; j++ {
+			m[fmt.Sprintf("key%d", j)] = "some string value"
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			enc := json.NewEncoder(io.Discard)
+			enc.Encode(m)
+		}
+	})
+}
@@ -6,9 +6,18 @@
 package johanson
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
+	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 type jsonContext interface {
@@ -16,14 +25,42 @@ type jsonContext interface {
 	pause(on bool)
 }
 
+// indentState holds the pretty-printing configuration and the current
+// nesting depth. It is shared (by pointer) between every context involved
+// in a single stream, since `Array` and `Object` recurse via callback and
+// need to agree on the depth of whatever is currently being written.
+type indentState struct {
+	prefix string
+	indent string
+	depth  int
+}
+
+// writeIndent writes a newline followed by prefix and indent repeated
+// depth times.
+func (is *indentState) writeIndent(w io.Writer, depth int) {
+	w.Write(newlineBytes)
+	if is.prefix != "" {
+		w.Write([]byte(is.prefix))
+	}
+	for i := 0; i < depth; i++ {
+		w.Write([]byte(is.indent))
+	}
+}
+
 type jsonContextArray struct {
 	paused   *bool
 	nonEmpty bool
+	indent   *indentState
 }
 
 func (ctx *jsonContextArray) prewrite(w io.Writer) bool {
-	if ctx.nonEmpty {
-		w.Write([]byte{','})
+	if ctx.indent != nil {
+		if ctx.nonEmpty {
+			w.Write(commaBytes)
+		}
+		ctx.indent.writeIndent(w, ctx.indent.depth)
+	} else if ctx.nonEmpty {
+		w.Write(commaBytes)
 	}
 	ctx.nonEmpty = true
 	return false // Multi value context.
@@ -33,10 +70,217 @@ func (ctx *jsonContextArray) pause(on bool) {
 	*ctx.paused = on
 }
 
+// StreamWriterOption configures the behavior of a stream writer created by
+// NewStreamWriter or NewIndentedStreamWriter.
+type StreamWriterOption func(*streamWriterConfig)
+
+type streamWriterConfig struct {
+	escapeHTML bool
+	bufferSize int
+}
+
+// EscapeHTML controls whether `<`, `>` and `&` are written as the JSON
+// unicode escapes of those characters in strings and object keys, mirroring
+// `encoding/json.Encoder.SetEscapeHTML`. It is enabled by default.
+func EscapeHTML(escape bool) StreamWriterOption {
+	return func(c *streamWriterConfig) {
+		c.escapeHTML = escape
+	}
+}
+
+// defaultBufferSize is the size of the internal write buffer used when
+// BufferSize isn't passed.
+const defaultBufferSize = 4096
+
+// BufferSize sets the size of the internal buffer that accumulates writes
+// before flushing them to the underlying writer. It defaults to 4 KiB. A
+// size of 0 (or less) disables the internal buffer, writing straight
+// through to the underlying writer instead.
+func BufferSize(size int) StreamWriterOption {
+	return func(c *streamWriterConfig) {
+		c.bufferSize = size
+	}
+}
+
+func newStreamWriterConfig(opts []StreamWriterOption) streamWriterConfig {
+	c := streamWriterConfig{escapeHTML: true, bufferSize: defaultBufferSize}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+var (
+	htmlEscLT  = []byte("\\u003c")
+	htmlEscGT  = []byte("\\u003e")
+	htmlEscAmp = []byte("\\u0026")
+)
+
+// Package-level punctuation byte slices, reused across writes so that
+// passing them through the io.Writer interface doesn't force the compiler
+// to heap-allocate a fresh slice on every call.
+var (
+	newlineBytes     = []byte{'\n'}
+	commaBytes       = []byte{','}
+	colonBytes       = []byte{':'}
+	arrayOpenBytes   = []byte{'['}
+	arrayCloseBytes  = []byte{']'}
+	objectOpenBytes  = []byte{'{'}
+	objectCloseBytes = []byte{'}'}
+)
+
+// scratchPool hands out scratch buffers for the numeric and string
+// formatters, so that the common case of a small value doesn't need to
+// allocate.
+var scratchPool = sync.Pool{
+	New: func() interface{} {
+		return new([64]byte)
+	},
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendEscapedString appends s, JSON-quoted and with special characters
+// escaped, to dst. It mirrors the escaping done by `json.Marshal`, with
+// `<`, `>` and `&` only escaped when escapeHTML is true.
+func appendEscapedString(dst []byte, s string, escapeHTML bool) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && !(escapeHTML && (c == '<' || c == '>' || c == '&')) {
+				i++
+				continue
+			}
+			if start < i {
+				dst = append(dst, s[start:i]...)
+			}
+			switch c {
+			case '"':
+				dst = append(dst, '\\', '"')
+			case '\\':
+				dst = append(dst, '\\', '\\')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			if start < i {
+				dst = append(dst, s[start:i]...)
+			}
+			dst = append(dst, `\ufffd`...) // Matches encoding/json's escaping of invalid UTF-8.
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON but break some JavaScript
+		// parsers (and old browsers) that treat them as line terminators;
+		// `encoding/json` always escapes them regardless of escapeHTML.
+		if r == '\u2028' || r == '\u2029' {
+			if start < i {
+				dst = append(dst, s[start:i]...)
+			}
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	if start < len(s) {
+		dst = append(dst, s[start:]...)
+	}
+	return append(dst, '"')
+}
+
+// appendFloat appends the JSON representation of f to dst, matching
+// `encoding/json`'s choice between 'e' and 'f' notation based on f's
+// exponent. The caller must have already rejected NaN/±Inf.
+func appendFloat(dst []byte, f float64) []byte {
+	abs := math.Abs(f)
+	fmtByte := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		fmtByte = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, fmtByte, -1, 64)
+	if fmtByte == 'e' {
+		// Clean up e-09 to e-9.
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+// unescapeHTML reverses the `<`, `>` and `&` escaping performed by
+// `json.Marshal`. A blind byte-substring replace is unsound: a literal
+// backslash followed by the literal text "u003c" (e.g. inside a string
+// describing JSON escapes) is doubled by Marshal to `\\u003c`, and a naive
+// search for the six-byte escape of `<` would match the last six of those
+// seven bytes and corrupt the output. So this walks the bytes left to right
+// and only treats the escape of `<`/`>`/`&` as a real one when the backslash
+// that introduces it isn't itself escaped, by consuming every escape
+// sequence it encounters at its full width.
+func unescapeHTML(b []byte) []byte {
+	if !bytes.Contains(b, []byte{'\\'}) {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		if b[i] != '\\' {
+			out = append(out, b[i])
+			i++
+			continue
+		}
+		if i+6 <= len(b) && b[i+1] == 'u' {
+			switch {
+			case bytes.Equal(b[i:i+6], htmlEscLT):
+				out = append(out, '<')
+			case bytes.Equal(b[i:i+6], htmlEscGT):
+				out = append(out, '>')
+			case bytes.Equal(b[i:i+6], htmlEscAmp):
+				out = append(out, '&')
+			default:
+				out = append(out, b[i:i+6]...)
+			}
+			i += 6
+			continue
+		}
+		if i+2 <= len(b) {
+			// Any other two-byte escape (\", \\, \/, \b, \f, \n, \r, \t).
+			out = append(out, b[i], b[i+1])
+			i += 2
+			continue
+		}
+		// Malformed trailing backslash; shouldn't occur in valid JSON.
+		out = append(out, b[i])
+		i++
+	}
+	return out
+}
+
 type val struct {
-	w      io.Writer
-	ctx    jsonContext
-	paused bool
+	w          io.Writer
+	ctx        jsonContext
+	paused     bool
+	indent     *indentState
+	escapeHTML bool
 }
 
 // The type used for the JSON value context.
@@ -56,6 +300,29 @@ func (ctx jsonContextSingle) pause(on bool) {
 	*ctx.paused = on
 }
 
+// jsonContextMultiSingle is the root context used by a JSON Lines writer.
+// Unlike jsonContextSingle it is never consumed: prewrite reports itself as
+// a multi value context (like jsonContextArray) so the root val is never
+// nil'd out after a value is written, and a new top-level value can be
+// started straight away, separated by sep.
+type jsonContextMultiSingle struct {
+	paused  *bool
+	sep     []byte
+	started bool
+}
+
+func (ctx *jsonContextMultiSingle) prewrite(w io.Writer) bool {
+	if ctx.started {
+		w.Write(ctx.sep)
+	}
+	ctx.started = true
+	return false // Multi value context.
+}
+
+func (ctx *jsonContextMultiSingle) pause(on bool) {
+	*ctx.paused = on
+}
+
 func (v *val) prewrite() (ok bool, single bool) {
 	if v == nil || v.w == nil || v.ctx == nil || v.paused {
 		return false, false
@@ -65,12 +332,34 @@ func (v *val) prewrite() (ok bool, single bool) {
 	}
 }
 
+// isSingleValueRootContext reports whether ctx is the root context of a
+// single-value stream (jsonContextSingle), as opposed to a context nested
+// inside an array or object, or the re-armable jsonContextMultiSingle root
+// used by a JSON Lines writer. jsonContextMultiSingle is deliberately
+// excluded: it completes a value on every record, and auto-flushing there
+// would defeat the internal buffer for the many-small-records workload
+// (log shipping, NDJSON) that writer is meant for. Callers of that writer
+// batch records until they explicitly call Flush (or the buffer fills).
+func isSingleValueRootContext(ctx jsonContext) bool {
+	_, ok := ctx.(jsonContextSingle)
+	return ok
+}
+
 func (v *val) postwrite(single bool) {
 	if v.ctx != nil {
+		isRoot := isSingleValueRootContext(v.ctx)
 		v.ctx.pause(false)
 		if single {
 			v.ctx = nil
 		}
+		if isRoot {
+			// A complete top-level value has just been written: flush the
+			// internal buffer so it becomes visible to the underlying
+			// writer without requiring the caller to call Flush.
+			if ww, ok := v.w.(*writerWrapper); ok {
+				ww.flush()
+			}
+		}
 	}
 }
 
@@ -89,9 +378,18 @@ func (v *val) Null() {
 // Only works if the value context is in a valid state.
 func (v *val) Marshal(value interface{}) error {
 	if ok, single := v.prewrite(); ok {
-		bytes, err := json.Marshal(value)
+		var out []byte
+		var err error
+		if v.indent != nil {
+			out, err = json.MarshalIndent(value, v.indent.prefix+strings.Repeat(v.indent.indent, v.indent.depth), v.indent.indent)
+		} else {
+			out, err = json.Marshal(value)
+		}
 		if err == nil {
-			v.w.Write(bytes)
+			if !v.escapeHTML {
+				out = unescapeHTML(out)
+			}
+			v.w.Write(out)
 		}
 		v.postwrite(single)
 		if err != nil {
@@ -118,7 +416,9 @@ func (v *val) Bool(val bool) {
 // Only works if the value context is in a valid state.
 func (v *val) Int(val int64) {
 	if ok, single := v.prewrite(); ok {
-		v.w.Write([]byte(strconv.FormatInt(val, 10)))
+		buf := scratchPool.Get().(*[64]byte)
+		v.w.Write(strconv.AppendInt(buf[:0], val, 10))
+		scratchPool.Put(buf)
 		v.postwrite(single)
 	}
 }
@@ -127,17 +427,29 @@ func (v *val) Int(val int64) {
 // Only works if the value context is in a valid state.
 func (v *val) Uint(val uint64) {
 	if ok, single := v.prewrite(); ok {
-		v.w.Write([]byte(strconv.FormatUint(val, 10)))
+		buf := scratchPool.Get().(*[64]byte)
+		v.w.Write(strconv.AppendUint(buf[:0], val, 10))
+		scratchPool.Put(buf)
 		v.postwrite(single)
 	}
 }
 
 // Write the provided floating point value to the stream.
+// NaN and ±Inf can't be represented in JSON; for those values nothing is
+// written and the error becomes available through Error() instead.
 // Only works if the value context is in a valid state.
 func (v *val) Float(value float64) {
 	if ok, single := v.prewrite(); ok {
-		bytes, _ := json.Marshal(value)
-		v.w.Write(bytes)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			if ww, ok := v.w.(*writerWrapper); ok && ww.Err == nil {
+				ww.Err = fmt.Errorf("json: unsupported value: %s", strconv.FormatFloat(value, 'g', -1, 64))
+			}
+			v.postwrite(single)
+			return
+		}
+		buf := scratchPool.Get().(*[64]byte)
+		v.w.Write(appendFloat(buf[:0], value))
+		scratchPool.Put(buf)
 		v.postwrite(single)
 	}
 }
@@ -147,8 +459,20 @@ func (v *val) Float(value float64) {
 // Only works if the value context is in a valid state.
 func (v *val) String(s string) {
 	if ok, single := v.prewrite(); ok {
-		bytes, _ := json.Marshal(s)
-		v.w.Write(bytes)
+		buf := scratchPool.Get().(*[64]byte)
+		v.w.Write(appendEscapedString(buf[:0], s, v.escapeHTML))
+		scratchPool.Put(buf)
+		v.postwrite(single)
+	}
+}
+
+// Raw writes a caller-supplied, already-valid JSON fragment into the
+// current value context, honoring the array-comma / object-key prewrite
+// like the other V methods. No validation is performed on p.
+// Only works if the value context is in a valid state.
+func (v *val) Raw(p []byte) {
+	if ok, single := v.prewrite(); ok {
+		v.w.Write(p)
 		v.postwrite(single)
 	}
 }
@@ -159,23 +483,34 @@ func (v *val) String(s string) {
 func (v *val) Array(fn func(V)) {
 	if ok, single := v.prewrite(); ok {
 		v.ctx.pause(true)
-		v.w.Write([]byte{'['})
+		v.w.Write(arrayOpenBytes)
 		if fn != nil {
-			a := val{w: v.w}
-			ctx := jsonContextArray{paused: &a.paused}
+			if v.indent != nil {
+				v.indent.depth++
+			}
+			a := val{w: v.w, indent: v.indent, escapeHTML: v.escapeHTML}
+			ctx := jsonContextArray{paused: &a.paused, indent: v.indent}
 			a.ctx = &ctx
 			fn(&a)
 			a.w = nil
+			if v.indent != nil {
+				v.indent.depth--
+				if ctx.nonEmpty {
+					v.indent.writeIndent(v.w, v.indent.depth)
+				}
+			}
 		}
-		v.w.Write([]byte{']'})
+		v.w.Write(arrayCloseBytes)
 		v.postwrite(single)
 	}
 }
 
 type obj struct {
-	w        io.Writer
-	nonEmpty bool
-	paused   bool
+	w          io.Writer
+	nonEmpty   bool
+	paused     bool
+	indent     *indentState
+	escapeHTML bool
 }
 
 // The type used for the JSON object context.
@@ -187,8 +522,13 @@ func (o *obj) prewrite() bool {
 	if o.w == nil && o.paused {
 		return false // Write not allowed.
 	} else {
-		if o.nonEmpty {
-			o.w.Write([]byte{','})
+		if o.indent != nil {
+			if o.nonEmpty {
+				o.w.Write(commaBytes)
+			}
+			o.indent.writeIndent(o.w, o.indent.depth)
+		} else if o.nonEmpty {
+			o.w.Write(commaBytes)
 		}
 		o.nonEmpty = true
 		return true // Write allowed.
@@ -206,9 +546,14 @@ func (ctx *jsonContextObjectItem) pause(on bool) {
 
 func (ctx *jsonContextObjectItem) prewrite(w io.Writer) bool {
 	if ctx.obj.prewrite() {
-		bytes, _ := json.Marshal(ctx.key)
-		w.Write(bytes)
-		w.Write([]byte{':'})
+		buf := scratchPool.Get().(*[64]byte)
+		w.Write(appendEscapedString(buf[:0], ctx.key, ctx.obj.escapeHTML))
+		scratchPool.Put(buf)
+		if ctx.obj.indent != nil {
+			w.Write([]byte(": "))
+		} else {
+			w.Write(colonBytes)
+		}
 	}
 	return true // Single value context.
 }
@@ -221,7 +566,7 @@ func (ctx *jsonContextObjectItem) prewrite(w io.Writer) bool {
 func (o *obj) Item(key string) V {
 	if !o.paused {
 		o.paused = true
-		return &val{w: o.w, ctx: &jsonContextObjectItem{key: key, obj: o}}
+		return &val{w: o.w, ctx: &jsonContextObjectItem{key: key, obj: o}, indent: o.indent, escapeHTML: o.escapeHTML}
 	}
 	return nil
 }
@@ -229,12 +574,15 @@ func (o *obj) Item(key string) V {
 // Marshal any object/map and write its contents to the JSON object context.
 // Only works if the object context is in a valid state.
 func (o *obj) Marshal(anyMap map[string]interface{}) error {
-	bytes, err := json.Marshal(anyMap)
-	if err != nil {
-		return err
+	keys := make([]string, 0, len(anyMap))
+	for key := range anyMap {
+		keys = append(keys, key)
 	}
-	if len(bytes) > 2 && o.prewrite() {
-		o.w.Write(bytes[1 : len(bytes)-1])
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := o.Item(key).Marshal(anyMap[key]); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -245,25 +593,64 @@ func (o *obj) Marshal(anyMap map[string]interface{}) error {
 func (v *val) Object(fn func(K)) {
 	if ok, single := v.prewrite(); ok {
 		v.ctx.pause(true)
-		v.w.Write([]byte{'{'})
+		v.w.Write(objectOpenBytes)
 		if fn != nil {
-			jso := obj{w: v.w}
+			if v.indent != nil {
+				v.indent.depth++
+			}
+			jso := obj{w: v.w, indent: v.indent, escapeHTML: v.escapeHTML}
 			fn(&jso)
 			jso.w = nil
+			if v.indent != nil {
+				v.indent.depth--
+				if jso.nonEmpty {
+					v.indent.writeIndent(v.w, v.indent.depth)
+				}
+			}
 		}
-		v.w.Write([]byte{'}'})
+		v.w.Write(objectCloseBytes)
 		v.postwrite(single)
 	}
 }
 
 type writerWrapper struct {
-	w   io.Writer
+	w   io.Writer // The underlying writer, as passed in by the caller.
+	buf *bufio.Writer
 	Err error
 }
 
+// errCaptureWriter forwards writes to w, recording the last error (if any)
+// into *err. It is used as the flush target of a writerWrapper's bufio
+// buffer, so that a write failure on the underlying writer is still
+// visible through Error() even though it only happens once the buffer is
+// flushed.
+type errCaptureWriter struct {
+	w   io.Writer
+	err *error
+}
+
+func (ecw *errCaptureWriter) Write(p []byte) (n int, err error) {
+	n, err = ecw.w.Write(p)
+	if err != nil {
+		*ecw.err = err
+	}
+	return
+}
+
+func newWriterWrapper(w io.Writer, bufferSize int) *writerWrapper {
+	ww := &writerWrapper{w: w}
+	if bufferSize > 0 {
+		ww.buf = bufio.NewWriterSize(&errCaptureWriter{w: w, err: &ww.Err}, bufferSize)
+	}
+	return ww
+}
+
 // Implements the io.Writer interface.
 // Keeps track of the last occurred error.
 func (ww *writerWrapper) Write(p []byte) (n int, err error) {
+	if ww.buf != nil {
+		return ww.buf.Write(p)
+	}
 	n, err = ww.w.Write(p)
 	if err != nil {
 		ww.Err = err
@@ -271,6 +658,15 @@ func (ww *writerWrapper) Write(p []byte) (n int, err error) {
 	return
 }
 
+// flush pushes any buffered bytes through to the underlying writer.
+func (ww *writerWrapper) flush() {
+	if ww.buf != nil {
+		if err := ww.buf.Flush(); err != nil {
+			ww.Err = err
+		}
+	}
+}
+
 // Check whether the JSON value context is finished.
 func (v *val) Finished() bool {
 	return v.ctx == nil
@@ -287,12 +683,58 @@ func (v *val) Error() error {
 	}
 }
 
+// Flush pushes any internally buffered bytes through to the underlying
+// writer, and, if that writer implements `http.Flusher`, asks it to push
+// what has been written so far to the client promptly. Any write error
+// encountered while doing so is surfaced through Error().
+func (v *val) Flush() {
+	ww, ok := v.w.(*writerWrapper)
+	if !ok {
+		return
+	}
+	ww.flush()
+	if f, ok := ww.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // NewStreamWriter instantiates a new JSON stream writer, using w as the
 // underlying writer.
 // It returns a JSON single value context to one value can be written.
-func NewStreamWriter(w io.Writer) V {
-	ww := &writerWrapper{w: w}
-	v := &val{w: ww}
+func NewStreamWriter(w io.Writer, opts ...StreamWriterOption) V {
+	c := newStreamWriterConfig(opts)
+	ww := newWriterWrapper(w, c.bufferSize)
+	v := &val{w: ww, escapeHTML: c.escapeHTML}
+	v.ctx = jsonContextSingle{paused: &v.paused}
+	return v
+}
+
+// NewIndentedStreamWriter instantiates a new JSON stream writer, using w as
+// the underlying writer, that produces pretty-printed output equivalent to
+// `encoding/json.MarshalIndent`: each array element and object item is
+// placed on its own line, prefixed by prefix plus indent repeated once per
+// nesting level. Empty arrays and objects are kept on a single line (`[]`,
+// `{}`).
+// It returns a JSON single value context to one value can be written.
+func NewIndentedStreamWriter(w io.Writer, prefix, indent string, opts ...StreamWriterOption) V {
+	c := newStreamWriterConfig(opts)
+	ww := newWriterWrapper(w, c.bufferSize)
+	v := &val{w: ww, indent: &indentState{prefix: prefix, indent: indent}, escapeHTML: c.escapeHTML}
 	v.ctx = jsonContextSingle{paused: &v.paused}
 	return v
 }
+
+// NewJSONLinesWriter instantiates a new JSON stream writer, using w as the
+// underlying writer, that writes newline-delimited JSON (JSON Lines /
+// NDJSON): unlike NewStreamWriter, the returned value context is not
+// consumed after one value is written, so any number of top-level values
+// can be written to it, each separated by a `\n`.
+// Call Flush after each value to push it to the underlying writer promptly
+// when streaming over HTTP.
+func NewJSONLinesWriter(w io.Writer, opts ...StreamWriterOption) V {
+	c := newStreamWriterConfig(opts)
+	ww := newWriterWrapper(w, c.bufferSize)
+	v := &val{w: ww, escapeHTML: c.escapeHTML}
+	v.ctx = &jsonContextMultiSingle{paused: &v.paused, sep: newlineBytes}
+	return v
+}